@@ -11,7 +11,8 @@ import (
 )
 
 var (
-	inFile = kingpin.Arg("file", "JSON file.").String()
+	reverse = kingpin.Flag("reverse", "Convert JSON to CSON instead.").Short('r').Bool()
+	inFile  = kingpin.Arg("file", "CSON file (JSON with --reverse).").String()
 )
 
 func main() {
@@ -26,6 +27,16 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *reverse {
+		out, err := cson.FromJSON(data)
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		fmt.Print(string(out))
+		return
+	}
+
 	out := cson.ToJSON(data)
 	fmt.Print(string(out))
 }
@@ -0,0 +1,131 @@
+package cson
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+// roundTrip feeds json through FromJSON then back through ToJSONErr and
+// compares the decoded values rather than the raw bytes, since FromJSON
+// is free to reorder/reformat keys.
+func roundTrip(t *testing.T, js string) {
+	t.Helper()
+	cs, err := FromJSON([]byte(js))
+	if err != nil {
+		t.Fatalf("FromJSON(%q): %v", js, err)
+	}
+	back, err := ToJSONErr(cs)
+	if err != nil {
+		t.Fatalf("ToJSONErr(%q) (from %q): %v", cs, js, err)
+	}
+	var want, got interface{}
+	if err := json.Unmarshal([]byte(js), &want); err != nil {
+		t.Fatalf("json.Unmarshal(%q): %v", js, err)
+	}
+	if err := json.Unmarshal(back, &got); err != nil {
+		t.Fatalf("json.Unmarshal(%q) (cson %q): %v", back, cs, err)
+	}
+	wantJSON, _ := json.Marshal(want)
+	gotJSON, _ := json.Marshal(got)
+	if !bytes.Equal(wantJSON, gotJSON) {
+		t.Errorf("round-trip mismatch:\n  original: %s\n  cson:     %s\n  got:      %s\n  want:     %s", js, cs, gotJSON, wantJSON)
+	}
+}
+
+func TestFromJSONRoundTrip(t *testing.T) {
+	cases := []string{
+		`{"a":1,"b":2}`,
+		`{"a":{"b":1,"c":2}}`,
+		`{"f":"a\n\"\"\"x\nb"}`,
+		`{"f":"  a\n  b"}`,
+		`{"f":"plain multiline\nstring"}`,
+		`{"servers":[{"host":"a"},{"host":"b"}]}`,
+		`{"n":-3.5,"ok":true,"bad":false,"nil":null}`,
+	}
+	for _, js := range cases {
+		roundTrip(t, js)
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	cases := []struct {
+		doc string
+		err error
+	}{
+		{"a: 'unterminated", ErrStringEarlyEnd},
+		{"a: ###\nunterminated\n", ErrCommentEarlyEnd},
+	}
+	for _, c := range cases {
+		_, err := ToJSONErr([]byte(c.doc))
+		if !errors.Is(err, c.err) {
+			t.Errorf("ToJSONErr(%q): got %v, want errors.Is match for %v", c.doc, err, c.err)
+		}
+	}
+
+	_, err := ToJSONStrict([]byte("a: 1,,\n"))
+	if !errors.Is(err, ErrAmbiguousComma) {
+		t.Errorf("ToJSONStrict(%q): got %v, want errors.Is match for ErrAmbiguousComma", "a: 1,,\n", err)
+	}
+}
+
+// TestLexerMatchesToJSON feeds the same documents through the streaming
+// Decoder (backed by lexer) and through ToJSONErr directly, and requires
+// byte-identical output: the Decoder is advertised as an incremental
+// equivalent of ToJSONErr, not an approximation of it.
+func TestLexerMatchesToJSON(t *testing.T) {
+	docs := []string{
+		"a: 1\nb: 2\n",
+		"a:\n  b: 1\n  c: 2\n",
+		"a: 5\n",
+		"a: -3.5\nb: 2\n",
+		"a:\n  5: x\n",
+		"a: 'hello world'\nb: \"quoted\\nstring\"\n",
+		"# comment\na: 1\n",
+	}
+	for _, doc := range docs {
+		direct, derr := ToJSONErr([]byte(doc))
+
+		lex := newLexer(bytes.NewReader([]byte(doc)), true, false)
+		streamed, serr := ioutil.ReadAll(lex)
+
+		if (derr == nil) != (serr == nil) {
+			t.Errorf("doc %q: ToJSONErr err=%v, lexer err=%v", doc, derr, serr)
+			continue
+		}
+		if derr == nil && !bytes.Equal(direct, streamed) {
+			t.Errorf("doc %q: ToJSONErr=%s, lexer=%s", doc, direct, streamed)
+		}
+	}
+}
+
+func TestStripBOM(t *testing.T) {
+	cases := []struct {
+		name string
+		in   []byte
+		want []byte
+	}{
+		{"utf8", append([]byte{0xEF, 0xBB, 0xBF}, []byte("a: 1\n")...), []byte("a: 1\n")},
+		{"none", []byte("a: 1\n"), []byte("a: 1\n")},
+	}
+	for _, c := range cases {
+		got := StripBOM(c.in)
+		if !bytes.Equal(got, c.want) {
+			t.Errorf("StripBOM(%s): got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestDecoderStripsBOM(t *testing.T) {
+	doc := append([]byte{0xEF, 0xBB, 0xBF}, []byte("a: 1\n")...)
+	dec := NewDecoder(bytes.NewReader(doc))
+	var v map[string]interface{}
+	if err := dec.Decode(&v); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if v["a"] != float64(1) {
+		t.Errorf("got %v, want a=1", v)
+	}
+}
@@ -0,0 +1,84 @@
+package cson
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Decoder reads and decodes CSON values from an input stream, mirroring
+// the encoding/json.Decoder API. Internally it runs a lexer that tracks
+// indent level, nesting, needComma, and partial strings/comments as state
+// that persists across reads, translating CSON into JSON one chunk at a
+// time and feeding the result into a json.Decoder for struct binding.
+// Unlike New/ToJSONErr it does not read all of r up front, except for
+// input that starts with a UTF-16 BOM, which is read in full to transcode it.
+type Decoder struct {
+	r        io.Reader
+	strict   bool
+	useNum   bool
+	lex      *lexer
+	dec      *json.Decoder
+	prepared bool
+}
+
+// NewDecoder returns a new Decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Strict toggles the extra ambiguous-comma/indent checks performed by
+// ToJSONStrict. It must be called before the first Decode/Token/More
+// call. The default is false, matching ToJSONErr.
+func (d *Decoder) Strict(strict bool) {
+	d.strict = strict
+}
+
+func (d *Decoder) prepare() {
+	if d.prepared {
+		return
+	}
+	d.prepared = true
+
+	d.lex = newLexer(d.r, true, d.strict)
+	d.dec = json.NewDecoder(d.lex)
+	if d.useNum {
+		d.dec.UseNumber()
+	}
+}
+
+// Decode reads the next JSON-encoded value from its input and stores it
+// in the value pointed to by v.
+func (d *Decoder) Decode(v interface{}) error {
+	d.prepare()
+	return d.dec.Decode(v)
+}
+
+// Token returns the next JSON token in the input stream.
+func (d *Decoder) Token() (json.Token, error) {
+	d.prepare()
+	return d.dec.Token()
+}
+
+// More reports whether there is another element in the current array or
+// object being parsed.
+func (d *Decoder) More() bool {
+	d.prepare()
+	return d.dec.More()
+}
+
+// Buffered returns a reader of the data remaining in the Decoder's
+// buffer.
+func (d *Decoder) Buffered() io.Reader {
+	d.prepare()
+	return d.dec.Buffered()
+}
+
+// UseNumber causes the Decoder to unmarshal a number into an interface{}
+// as a json.Number instead of as a float64. Like Strict, it must be
+// called before the first Decode/Token/More call.
+func (d *Decoder) UseNumber() {
+	d.useNum = true
+	if d.dec != nil {
+		d.dec.UseNumber()
+	}
+}
@@ -0,0 +1,222 @@
+package cson
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var bareKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+var bareValuePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_ .-]*$`)
+
+// Encoder writes CSON values to an output stream.
+type Encoder struct {
+	w        io.Writer
+	comments map[string]string
+}
+
+// NewEncoder returns a new Encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// SetComment attaches a "#" comment to the value at the given JSON
+// pointer (e.g. "/servers/host"), written immediately before that value
+// the next time Encode is called. Only pointers into nested objects are
+// honored: writeObjectBody is the only place comments are looked up, and
+// it never recurses into arrays, so a pointer through an array index
+// (e.g. "/servers/0/host") is silently ignored.
+func (e *Encoder) SetComment(pointer, comment string) {
+	if e.comments == nil {
+		e.comments = map[string]string{}
+	}
+	e.comments[pointer] = comment
+}
+
+// Encode writes the CSON encoding of v to the stream, followed by a
+// newline.
+func (e *Encoder) Encode(v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var val interface{}
+	if err := dec.Decode(&val); err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	if obj, ok := val.(map[string]interface{}); ok {
+		writeObjectBody(buf, obj, 0, "", e.comments)
+	} else {
+		buf.Write(raw)
+		buf.WriteByte('\n')
+	}
+	_, err = e.w.Write(buf.Bytes())
+	return err
+}
+
+// writeObjectBody writes obj's keys sorted, one "key: value" per line,
+// indented two spaces per level. Nested objects are written as further
+// indented blocks instead of "{}" blocks; everything else (arrays,
+// strings, numbers, bools, null) is written inline as JSON. Comments are
+// only looked up here, so a comment pointer that reaches into an array
+// (see SetComment) never gets a chance to attach to anything.
+func writeObjectBody(buf *bytes.Buffer, obj map[string]interface{}, indent int, pointer string, comments map[string]string) {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		child := pointer + "/" + k
+		if c, ok := comments[child]; ok && c != "" {
+			writeIndent(buf, indent)
+			buf.WriteString("# ")
+			buf.WriteString(c)
+			buf.WriteByte('\n')
+		}
+
+		writeIndent(buf, indent)
+		writeKey(buf, k)
+		buf.WriteByte(':')
+
+		if nested, ok := obj[k].(map[string]interface{}); ok {
+			buf.WriteByte('\n')
+			writeObjectBody(buf, nested, indent+1, child, comments)
+		} else {
+			buf.WriteByte(' ')
+			writeValue(buf, obj[k], indent)
+			buf.WriteByte('\n')
+		}
+	}
+}
+
+// writeValue writes a single non-object value: strings are unquoted when
+// safe to do so and re-indented triple-quoted blocks when multi-line,
+// numbers/bools/null are written as their JSON literal, and anything
+// else (arrays) falls back to compact JSON.
+func writeValue(buf *bytes.Buffer, v interface{}, indent int) {
+	switch t := v.(type) {
+	case string:
+		writeStringValue(buf, t, indent)
+	case json.Number:
+		buf.WriteString(t.String())
+	case bool:
+		if t {
+			buf.WriteString("true")
+		} else {
+			buf.WriteString("false")
+		}
+	case nil:
+		buf.WriteString("null")
+	default:
+		raw, _ := json.Marshal(t)
+		buf.Write(raw)
+	}
+}
+
+func writeStringValue(buf *bytes.Buffer, s string, indent int) {
+	if strings.Contains(s, "\n") && canUseMultiline(s) {
+		writeMultilineString(buf, s, indent)
+		return
+	}
+	if canUnquote(s) {
+		buf.WriteString(s)
+		return
+	}
+	raw, _ := json.Marshal(s)
+	buf.Write(raw)
+}
+
+// canUseMultiline reports whether s can round-trip losslessly through a
+// triple-quoted block: it must not itself contain a """ (which would
+// close the block early) and it must not have leading whitespace shared
+// by every one of its non-blank lines, since getString's minIndent
+// dedent would then strip more than just the block's own indentation.
+func canUseMultiline(s string) bool {
+	if strings.Contains(s, `"""`) {
+		return false
+	}
+	return minLeadingSpace(strings.Split(s, "\n")) == 0
+}
+
+// minLeadingSpace mirrors the minIndent calculation getStringErr performs
+// when parsing a multi-line string back in: the smallest number of
+// leading spaces shared by every non-blank line, or 0 if there are none.
+func minLeadingSpace(lines []string) int {
+	min := -1
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		n := 0
+		for n < len(line) && line[n] == ' ' {
+			n++
+		}
+		if min == -1 || n < min {
+			min = n
+		}
+	}
+	if min == -1 {
+		return 0
+	}
+	return min
+}
+
+// writeMultilineString writes s as a triple-quoted block, re-indenting
+// every line to indent+1 levels; the closing quotes follow the last line
+// directly rather than standing on a line of their own. This is
+// the inverse of the minIndent dedent getString applies when parsing one
+// of these blocks back in.
+func writeMultilineString(buf *bytes.Buffer, s string, indent int) {
+	pad := strings.Repeat("  ", indent+1)
+	lines := strings.Split(s, "\n")
+	buf.WriteString(`"""`)
+	buf.WriteByte('\n')
+	for i, line := range lines {
+		buf.WriteString(pad)
+		buf.WriteString(line)
+		if i < len(lines)-1 {
+			buf.WriteByte('\n')
+		}
+	}
+	buf.WriteString(`"""`)
+}
+
+// canUnquote reports whether s can be written as a bare word without
+// changing its meaning on the way back in: it must not parse as a
+// keyword or a number, and must match the bare word grammar getWord
+// accepts.
+func canUnquote(s string) bool {
+	// getWord trims trailing whitespace, so s must not end with any
+	if s == "" || s != strings.TrimSpace(s) || isKeyword([]byte(s)) {
+		return false
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return false
+	}
+	return bareValuePattern.MatchString(s)
+}
+
+func writeIndent(buf *bytes.Buffer, indent int) {
+	for i := 0; i < indent; i++ {
+		buf.WriteString("  ")
+	}
+}
+
+func writeKey(buf *bytes.Buffer, k string) {
+	if bareKeyPattern.MatchString(k) {
+		buf.WriteString(k)
+		return
+	}
+	raw, _ := json.Marshal(k)
+	buf.Write(raw)
+}
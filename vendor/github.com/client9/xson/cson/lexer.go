@@ -0,0 +1,399 @@
+package cson
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"strconv"
+)
+
+// lexer incrementally translates CSON bytes read from an io.Reader into
+// JSON bytes, pulling more input from the source only when the token
+// currently being scanned (a string, comment, or bare word) runs off the
+// end of what's buffered so far. It implements the same indent/nest/
+// needComma/brackets state machine as toJSON, just as fields that persist
+// across Read calls instead of locals that require the whole document up
+// front. This is what lets Decoder work from a stream.
+type lexer struct {
+	src *bufio.Reader
+	buf []byte // unconsumed raw CSON bytes
+	eof bool   // src is exhausted; buf is everything that's left
+
+	commaStrict bool // ToJSONStrict-style ambiguous comma/indent checks
+	strict      bool // report syntax errors instead of coercing them
+
+	initialized bool
+	done        bool
+	err         error
+
+	nest          int
+	currentIndent int
+	lastIndent    int
+	line          int
+	column        int
+	consumed      int
+	last          byte
+	brackets      []bool
+	needComma     bool
+
+	out bytes.Buffer
+}
+
+func newLexer(r io.Reader, strict, commaStrict bool) *lexer {
+	return &lexer{
+		src:         bufio.NewReader(r),
+		strict:      strict,
+		commaStrict: commaStrict,
+		nest:        1,
+		line:        1,
+	}
+}
+
+// Read implements io.Reader, producing translated JSON bytes. It steps the
+// state machine forward until it has at least len(p) bytes buffered (or
+// hits EOF/an error), so callers reading in reasonably sized chunks don't
+// pay for a syscall-sized round trip per CSON token.
+func (l *lexer) Read(p []byte) (int, error) {
+	if !l.initialized {
+		l.initialized = true
+		l.init()
+	}
+	for l.out.Len() < len(p) && l.err == nil && !l.done {
+		l.step()
+	}
+	if l.out.Len() > 0 {
+		return l.out.Read(p)
+	}
+	if l.err != nil {
+		return 0, l.err
+	}
+	return 0, io.EOF
+}
+
+// init strips a leading BOM, same as StripBOM, before the state machine
+// sees its first byte. UTF-16 input has to be transcoded in one pass, so
+// it's read in full here rather than incrementally; that's an acceptable
+// trade-off since a UTF-16-BOM'd CSON document is the rare case, and the
+// common UTF-8 (or BOM-less) case stays fully incremental.
+func (l *lexer) init() {
+	l.need(4)
+	switch {
+	case len(l.buf) >= 3 && l.buf[0] == 0xEF && l.buf[1] == 0xBB && l.buf[2] == 0xBF:
+		l.consume(3)
+	case len(l.buf) >= 2 && l.buf[0] == 0xFF && l.buf[1] == 0xFE:
+		l.slurpUTF16(binary.LittleEndian)
+	case len(l.buf) >= 2 && l.buf[0] == 0xFE && l.buf[1] == 0xFF:
+		l.slurpUTF16(binary.BigEndian)
+	}
+	l.out.WriteByte('{')
+}
+
+func (l *lexer) slurpUTF16(order binary.ByteOrder) {
+	l.consume(2)
+	rest, _ := ioutil.ReadAll(l.src)
+	l.buf = utf16ToUTF8(append(l.buf, rest...), order)
+	l.eof = true
+}
+
+// fill reads one more chunk from src into buf, unless src is exhausted.
+func (l *lexer) fill() {
+	if l.eof {
+		return
+	}
+	chunk := make([]byte, 4096)
+	n, err := l.src.Read(chunk)
+	if n > 0 {
+		l.buf = append(l.buf, chunk[:n]...)
+	}
+	if err != nil {
+		l.eof = true
+	}
+}
+
+// need grows buf until it holds at least n bytes, or src is exhausted.
+func (l *lexer) need(n int) {
+	for len(l.buf) < n && !l.eof {
+		l.fill()
+	}
+}
+
+// consume advances past the first n bytes of buf, which have already been
+// translated into l.out.
+func (l *lexer) consume(n int) {
+	l.buf = l.buf[n:]
+	l.consumed += n
+}
+
+func (l *lexer) inArray() bool {
+	return len(l.brackets) > 0 && l.brackets[len(l.brackets)-1]
+}
+
+func (l *lexer) syntaxErr(err error) *SyntaxError {
+	return &SyntaxError{Line: l.line, Column: l.column + 1, Offset: l.consumed, Msg: err.Error(), Err: err}
+}
+
+func (l *lexer) fail(err error) {
+	l.err = l.syntaxErr(err)
+	l.done = true
+}
+
+// scanString is getStringErr, refilling buf and retrying as long as the
+// closing quote simply hasn't arrived yet. getStringErr also needs at
+// least 4 bytes buffered just to tell a single- from a triple-quoted
+// string apart (its own len(s) > 3 check), so that's ensured up front
+// too: otherwise a triple-quoted string fed one byte at a time would be
+// misread as a single-quoted one before its 4th byte ever arrives.
+func (l *lexer) scanString() (content []byte, n int, err error) {
+	l.need(4)
+	for {
+		content, n, err = getStringErr(l.buf)
+		if err == ErrStringEarlyEnd && !l.eof {
+			l.fill()
+			continue
+		}
+		return
+	}
+}
+
+// scanComment is getCommentErr, refilling buf and retrying as long as a
+// multi-line "###" comment's closing "###" simply hasn't arrived yet.
+// getCommentErr also tells single- from multi-line comments apart by
+// where the first real '\n' falls, so that newline (or true EOF) has to
+// be buffered before the first call: otherwise a short buffer's missing
+// newline (bytes.IndexByte returning -1) reads the same as the "comment
+// ends right here with no trailing newline" case and gets misread as a
+// complete single-line comment before the rest of it ever arrives.
+func (l *lexer) scanComment() (comment []byte, err error) {
+	for bytes.IndexByte(l.buf, '\n') == -1 && !l.eof {
+		l.fill()
+	}
+	for {
+		comment, err = getCommentErr(l.buf)
+		if err == ErrCommentEarlyEnd && !l.eof {
+			l.fill()
+			continue
+		}
+		return
+	}
+}
+
+// scanWord is getWordErr, refilling buf and retrying as long as the word
+// runs all the way to the end of what's buffered: that could just mean
+// more of the word is still arriving.
+func (l *lexer) scanWord() (word []byte, err error) {
+	for {
+		word, err = getWordErr(l.buf)
+		if err == nil && len(word) == len(l.buf) && !l.eof {
+			l.fill()
+			continue
+		}
+		return
+	}
+}
+
+// nextSignificantAfter is nextSignificant(buf[end:]), refilling buf and
+// retrying as long as it runs out of buffered bytes before finding a
+// non-whitespace, non-comment byte (or real EOF).
+func (l *lexer) nextSignificantAfter(end int) byte {
+	for {
+		if end <= len(l.buf) {
+			if c := nextSignificant(l.buf[end:]); c != 0 || l.eof {
+				return c
+			}
+		}
+		if l.eof {
+			return 0
+		}
+		l.fill()
+	}
+}
+
+// checkKeyHasColon is toJSON's closure of the same name, adapted to look
+// ahead via nextSignificantAfter instead of direct slicing.
+func (l *lexer) checkKeyHasColon(consumedLast byte, end int) error {
+	if !l.commaStrict || l.inArray() || consumedLast == ':' {
+		return nil
+	}
+	if l.nextSignificantAfter(end) != ':' {
+		return ErrMissingColon
+	}
+	return nil
+}
+
+// step processes one token (or, for whitespace, one byte) of input,
+// mirroring one iteration of toJSON's for loop body.
+func (l *lexer) step() {
+	if len(l.buf) == 0 && !l.eof {
+		l.fill()
+	}
+	if len(l.buf) == 0 {
+		// nest counts indentation levels, which close implicitly at
+		// EOF and are not an error. An explicit '{' or '[' left on
+		// brackets, on the other hand, was never matched by its
+		// closing delimiter.
+		if l.strict && len(l.brackets) > 0 {
+			l.fail(ErrUnexpectedEOF)
+			return
+		}
+		for i := 0; i < l.nest; i++ {
+			l.out.WriteByte('}')
+		}
+		l.done = true
+		return
+	}
+
+	switch l.buf[0] {
+	case ' ', '\t':
+		l.consume(1)
+		l.column++
+		// currentIndent == -1 means this line's indent was already
+		// classified by an earlier token (e.g. the key before a
+		// ':'); leave it alone so a value later on the same line
+		// isn't mistaken for a change in nesting depth.
+		if l.currentIndent != -1 {
+			l.currentIndent++
+		}
+	case '\n', '\r':
+		l.consume(1)
+		l.line++
+		l.column = 0
+		l.currentIndent = 0
+	case '#':
+		comment, err := l.scanComment()
+		if err != nil && l.strict {
+			l.fail(err)
+			return
+		}
+		advancePos(comment, &l.line, &l.column)
+		l.consume(len(comment))
+	case ':':
+		if l.commaStrict && l.last != 'v' {
+			l.fail(ErrMissingKey)
+			return
+		}
+		l.needComma = false
+		l.out.WriteByte(':')
+		l.consume(1)
+		l.column++
+		l.last = ':'
+	case '{':
+		writeComma(&l.out, l.needComma)
+		l.needComma = false
+		l.out.WriteByte('{')
+		l.brackets = append(l.brackets, false)
+		l.consume(1)
+		l.column++
+		l.last = '{'
+	case '[':
+		writeComma(&l.out, l.needComma)
+		l.needComma = false
+		l.out.WriteByte('[')
+		l.brackets = append(l.brackets, true)
+		l.consume(1)
+		l.column++
+		l.last = '['
+	case '}':
+		l.needComma = true
+		l.out.WriteByte('}')
+		if len(l.brackets) > 0 {
+			l.brackets = l.brackets[:len(l.brackets)-1]
+		}
+		l.consume(1)
+		l.column++
+		l.last = '}'
+	case ']':
+		l.needComma = true
+		l.out.WriteByte(']')
+		if len(l.brackets) > 0 {
+			l.brackets = l.brackets[:len(l.brackets)-1]
+		}
+		l.consume(1)
+		l.column++
+		l.last = ']'
+	case ',':
+		if l.commaStrict && (l.last == ',' || l.last == '{' || l.last == '[') {
+			l.fail(ErrAmbiguousComma)
+			return
+		}
+		l.needComma = true
+		l.consume(1)
+		l.column++
+		l.last = ','
+	case '\'', '"':
+		wasLast := l.last
+		l.needComma = writeComma(&l.out, l.needComma)
+		content, n, err := l.scanString()
+		if err != nil && l.strict {
+			l.fail(err)
+			return
+		}
+		l.out.WriteByte('"')
+		l.out.Write(content)
+		l.out.WriteByte('"')
+		if err := l.checkKeyHasColon(wasLast, n); err != nil && l.commaStrict {
+			l.fail(err)
+			return
+		}
+		advancePos(l.buf[:n], &l.line, &l.column)
+		l.consume(n)
+		// see the '+'..'9' and default cases: a quoted string is
+		// just as much a complete value/key as a bare word is, so it
+		// must unmask currentIndent the same way.
+		l.currentIndent = -1
+		l.last = 'v'
+	case '+', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		l.needComma = writeComma(&l.out, l.needComma)
+		word, err := l.scanWord()
+		if err != nil && l.strict {
+			l.fail(err)
+			return
+		}
+		_, perr := strconv.ParseFloat(string(word), 64)
+		writeWord(&l.out, word, perr != nil)
+		advancePos(word, &l.line, &l.column)
+		l.consume(len(word))
+		l.last = 'v'
+	default:
+		wasLast := l.last
+		if l.currentIndent == -1 {
+			// a continuation of the same line, e.g. the value
+			// right after ':' regardless of how many spaces
+			// followed it. It still needs the same comma
+			// bookkeeping as a same-level bare word, just
+			// without touching nest/lastIndent: it isn't a
+			// change in nesting depth.
+			l.needComma = writeComma(&l.out, l.needComma)
+		} else if l.currentIndent < l.lastIndent {
+			if l.commaStrict && l.nest <= 1 {
+				l.fail(ErrUnexpectedDedent)
+				return
+			}
+			l.nest--
+			l.out.WriteByte('}')
+			l.out.WriteByte(',')
+			l.lastIndent = l.currentIndent
+		} else if l.currentIndent == l.lastIndent {
+			l.needComma = writeComma(&l.out, l.needComma)
+		} else {
+			l.nest++
+			l.out.WriteByte('{')
+			l.lastIndent = l.currentIndent
+		}
+		l.currentIndent = -1
+		word, err := l.scanWord()
+		if err != nil && l.strict {
+			l.fail(err)
+			return
+		}
+		if err := l.checkKeyHasColon(wasLast, len(word)); err != nil && l.commaStrict {
+			l.fail(err)
+			return
+		}
+		l.last = 'v'
+		writeWord(&l.out, word, !isKeyword(word))
+		advancePos(word, &l.line, &l.column)
+		l.consume(len(word))
+	}
+}
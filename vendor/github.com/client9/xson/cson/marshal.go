@@ -0,0 +1,50 @@
+package cson
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// MarshalOptions controls optional behavior of Marshal/FromJSON beyond
+// the plain CSON encoding Encoder produces on its own.
+type MarshalOptions struct {
+	// Comments attaches a "#" comment to the value at each JSON
+	// pointer key (e.g. "/servers/host"). Only pointers into nested
+	// objects are honored; see Encoder.SetComment.
+	Comments map[string]string
+}
+
+// Marshal returns the CSON encoding of v.
+func Marshal(v interface{}) ([]byte, error) {
+	return MarshalWithOptions(v, MarshalOptions{})
+}
+
+// MarshalWithOptions is like Marshal but lets the caller attach comments
+// via MarshalOptions.
+func MarshalWithOptions(v interface{}, opts MarshalOptions) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := NewEncoder(buf)
+	for pointer, comment := range opts.Comments {
+		enc.SetComment(pointer, comment)
+	}
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// FromJSON converts JSON into human-friendly CSON: keys matching
+// [A-Za-z_][A-Za-z0-9_-]* unquoted, string values unquoted where safe,
+// two-space indent blocks instead of "{}", and triple-quoted blocks for
+// multi-line strings. The keys true, false and null match that pattern
+// too and are written bare; ToJSON reads such a key back as an unquoted
+// literal, which is not valid JSON.
+func FromJSON(jsonBytes []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(jsonBytes))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return Marshal(v)
+}